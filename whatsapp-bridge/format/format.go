@@ -0,0 +1,215 @@
+// Package format converts WhatsApp's inline text formatting (*bold*,
+// _italic_, ~strike~, ```mono```) and @-mentions into Markdown or HTML.
+package format
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+type nodeKind int
+
+const (
+	nodeText nodeKind = iota
+	nodeBold
+	nodeItalic
+	nodeStrike
+	nodeMono
+	nodeMention
+	nodeLink
+)
+
+type node struct {
+	kind     nodeKind
+	text     string // literal text for nodeText/nodeMono, JID for nodeMention, URL for nodeLink
+	children []node // inner nodes for nodeBold/nodeItalic/nodeStrike
+}
+
+// parse tokenises text into a tree of formatting nodes, honoring
+// WhatsApp's rule that a marker only applies when its opening side is
+// preceded by whitespace/punctuation/start-of-string and its closing side
+// is followed by whitespace/punctuation/end-of-string (so "5*3=15" is left
+// alone but "you should *really* look at this" becomes bold).
+func parse(text string, mentions []string) []node {
+	mentionSet := make(map[string]bool, len(mentions))
+	for _, m := range mentions {
+		// A full JID ("<user>@<server>") is keyed by its user part, which is
+		// what follows the "@" in the message text.
+		if at := strings.IndexByte(m, '@'); at >= 0 {
+			m = m[:at]
+		}
+		mentionSet[m] = true
+	}
+
+	nodes := []node{{kind: nodeText, text: text}}
+	nodes = applyStage(nodes, extractMono)
+	nodes = applyStage(nodes, func(t string) []node { return extractMarker(t, "~", nodeStrike) })
+	nodes = applyStage(nodes, func(t string) []node { return extractMarker(t, "*", nodeBold) })
+	nodes = applyStage(nodes, func(t string) []node { return extractMarker(t, "_", nodeItalic) })
+	nodes = applyStage(nodes, func(t string) []node { return extractMentionsAndLinks(t, mentionSet) })
+	return nodes
+}
+
+// applyStage runs fn over every text leaf in nodes, including leaves
+// nested inside nodes produced by earlier stages, so later stages still
+// see (and can further tokenise) the inner content of a bold/italic/strike
+// span.
+func applyStage(nodes []node, fn func(string) []node) []node {
+	out := make([]node, 0, len(nodes))
+	for _, n := range nodes {
+		if n.kind == nodeText {
+			out = append(out, fn(n.text)...)
+			continue
+		}
+		n.children = applyStage(n.children, fn)
+		out = append(out, n)
+	}
+	return out
+}
+
+func isBoundaryRune(r rune, atEdge bool) bool {
+	return atEdge || unicode.IsSpace(r) || unicode.IsPunct(r) || unicode.IsSymbol(r)
+}
+
+// extractMono pulls out ```mono``` spans. Their content is never
+// reparsed, so a literal "*" inside a code span isn't mistaken for bold.
+func extractMono(text string) []node {
+	return extractDelimited(text, "```", nodeMono, false)
+}
+
+// extractMarker pulls out marker-delimited spans (e.g. *bold*), leaving
+// their inner text as a further-parseable nodeText child so later stages
+// can still tokenise nested formatting.
+func extractMarker(text, marker string, kind nodeKind) []node {
+	return extractDelimited(text, marker, kind, true)
+}
+
+func extractDelimited(text, marker string, kind nodeKind, reparseInner bool) []node {
+	runes := []rune(text)
+	markerRunes := []rune(marker)
+
+	var out []node
+	i := 0
+	for i < len(runes) {
+		start := findValidOpen(runes, markerRunes, i)
+		if start < 0 {
+			break
+		}
+		end := findValidClose(runes, markerRunes, start+len(markerRunes))
+		if end < 0 {
+			break
+		}
+
+		if start > i {
+			out = append(out, node{kind: nodeText, text: string(runes[i:start])})
+		}
+
+		inner := string(runes[start+len(markerRunes) : end])
+		if reparseInner {
+			out = append(out, node{kind: kind, children: []node{{kind: nodeText, text: inner}}})
+		} else {
+			out = append(out, node{kind: kind, text: inner})
+		}
+
+		i = end + len(markerRunes)
+	}
+
+	if i < len(runes) {
+		out = append(out, node{kind: nodeText, text: string(runes[i:])})
+	}
+	return out
+}
+
+// findValidOpen returns the index of the first occurrence of marker at or
+// after from whose opening side satisfies WhatsApp's boundary rule, or -1.
+func findValidOpen(runes, marker []rune, from int) int {
+	for i := from; i+len(marker) <= len(runes); i++ {
+		if !runesEqual(runes[i:i+len(marker)], marker) {
+			continue
+		}
+		precededByBoundary := i == 0 || isBoundaryRune(runes[i-1], false)
+		next := i + len(marker)
+		followedByContent := next < len(runes) && !unicode.IsSpace(runes[next])
+		if precededByBoundary && followedByContent {
+			return i
+		}
+	}
+	return -1
+}
+
+// findValidClose returns the index of the first occurrence of marker at or
+// after from whose closing side satisfies WhatsApp's boundary rule, or -1.
+func findValidClose(runes, marker []rune, from int) int {
+	for i := from; i+len(marker) <= len(runes); i++ {
+		if !runesEqual(runes[i:i+len(marker)], marker) {
+			continue
+		}
+		precededByContent := i > 0 && !unicode.IsSpace(runes[i-1])
+		next := i + len(marker)
+		followedByBoundary := next >= len(runes) || isBoundaryRune(runes[next], false)
+		if precededByContent && followedByBoundary {
+			return i
+		}
+	}
+	return -1
+}
+
+func runesEqual(a, b []rune) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+var (
+	mentionPattern = regexp.MustCompile(`@(\d{5,20})`)
+	urlPattern     = regexp.MustCompile(`https?://[^\s]+`)
+)
+
+// extractMentionsAndLinks splits text on @<jid> mentions (only those
+// actually present in mentionSet) and bare URLs, leaving everything else
+// as plain text.
+func extractMentionsAndLinks(text string, mentionSet map[string]bool) []node {
+	var out []node
+	remaining := text
+
+	for len(remaining) > 0 {
+		mentionLoc := mentionPattern.FindStringSubmatchIndex(remaining)
+		urlLoc := urlPattern.FindStringIndex(remaining)
+
+		useMention := mentionLoc != nil && mentionSet[remaining[mentionLoc[2]:mentionLoc[3]]] &&
+			(urlLoc == nil || mentionLoc[0] <= urlLoc[0])
+		useURL := !useMention && urlLoc != nil
+
+		switch {
+		case useMention:
+			if mentionLoc[0] > 0 {
+				out = append(out, node{kind: nodeText, text: remaining[:mentionLoc[0]]})
+			}
+			out = append(out, node{kind: nodeMention, text: remaining[mentionLoc[2]:mentionLoc[3]]})
+			remaining = remaining[mentionLoc[1]:]
+		case useURL:
+			if urlLoc[0] > 0 {
+				out = append(out, node{kind: nodeText, text: remaining[:urlLoc[0]]})
+			}
+			out = append(out, node{kind: nodeLink, text: remaining[urlLoc[0]:urlLoc[1]]})
+			remaining = remaining[urlLoc[1]:]
+		case mentionLoc != nil:
+			// A @<digits> run that isn't a real mention: emit it as plain
+			// text up to and including the digits, then keep scanning.
+			out = append(out, node{kind: nodeText, text: remaining[:mentionLoc[1]]})
+			remaining = remaining[mentionLoc[1]:]
+		default:
+			out = append(out, node{kind: nodeText, text: remaining})
+			remaining = ""
+		}
+	}
+
+	return out
+}
@@ -0,0 +1,63 @@
+package format
+
+import "testing"
+
+func TestToMarkdown(t *testing.T) {
+	tests := []struct {
+		name     string
+		text     string
+		mentions []string
+		want     string
+	}{
+		{"plain text", "hello world", nil, "hello world"},
+		{"bold", "you should *really* look at this", nil, "you should **really** look at this"},
+		{"italic", "it was _amazing_", nil, "it was *amazing*"},
+		{"strike", "not ~this one~", nil, "not ~~this one~~"},
+		{"mono", "run ```go build``` first", nil, "run `go build` first"},
+		{"mono content with asterisk untouched", "```a*b*c```", nil, "`a*b*c`"},
+		{"asymmetric marker mid-word left alone", "5*3=15", nil, "5*3=15"},
+		{"nested bold and italic", "*bold _and italic_*", nil, "**bold *and italic***"},
+		{"mention resolved when in list", "hi @1234567890", []string{"1234567890"}, "hi @1234567890"},
+		{"mention at EOL", "cc @1234567890", []string{"1234567890"}, "cc @1234567890"},
+		{"digits without @ left as text", "call 1234567890 now", []string{"1234567890"}, "call 1234567890 now"},
+		{"unmatched marker left alone", "half *bold", nil, "half *bold"},
+		{"autolink preserved", "see https://example.com/x for details", nil, "see <https://example.com/x> for details"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ToMarkdown(tt.text, tt.mentions)
+			if got != tt.want {
+				t.Errorf("ToMarkdown(%q, %v) = %q, want %q", tt.text, tt.mentions, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestToHTML(t *testing.T) {
+	names := map[string]string{"1234567890": "Alice"}
+	jidToName := func(jid string) string { return names[jid] }
+
+	tests := []struct {
+		name     string
+		text     string
+		mentions []string
+		want     string
+	}{
+		{"escapes html", "<script>alert(1)</script>", nil, "&lt;script&gt;alert(1)&lt;/script&gt;"},
+		{"bold", "*hello*", nil, "<b>hello</b>"},
+		{"mono escapes inner html", "```<b>```", nil, "<code>&lt;b&gt;</code>"},
+		{"mention resolved to name", "hi @1234567890", []string{"1234567890"}, `hi <a href="#" class="mention" data-jid="1234567890">@Alice</a>`},
+		{"mention unresolved falls back to jid", "hi @9999999999", []string{"9999999999"}, `hi <a href="#" class="mention" data-jid="9999999999">@9999999999</a>`},
+		{"autolink", "see https://example.com", nil, `see <a href="https://example.com">https://example.com</a>`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ToHTML(tt.text, tt.mentions, jidToName)
+			if got != tt.want {
+				t.Errorf("ToHTML(%q, %v) = %q, want %q", tt.text, tt.mentions, got, tt.want)
+			}
+		})
+	}
+}
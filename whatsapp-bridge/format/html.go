@@ -0,0 +1,60 @@
+package format
+
+import (
+	"html"
+	"strings"
+)
+
+// ToHTML converts WhatsApp's inline formatting and @-mentions in text into
+// HTML, escaping any HTML already present in the message and resolving
+// @<jid> mentions to display names via jidToName. A mention jidToName
+// can't resolve is rendered as "@<jid>".
+func ToHTML(text string, mentions []string, jidToName func(string) string) string {
+	var b strings.Builder
+	renderHTML(&b, parse(text, mentions), jidToName)
+	return b.String()
+}
+
+func renderHTML(b *strings.Builder, nodes []node, jidToName func(string) string) {
+	for _, n := range nodes {
+		switch n.kind {
+		case nodeText:
+			b.WriteString(html.EscapeString(n.text))
+		case nodeBold:
+			b.WriteString("<b>")
+			renderHTML(b, n.children, jidToName)
+			b.WriteString("</b>")
+		case nodeItalic:
+			b.WriteString("<i>")
+			renderHTML(b, n.children, jidToName)
+			b.WriteString("</i>")
+		case nodeStrike:
+			b.WriteString("<s>")
+			renderHTML(b, n.children, jidToName)
+			b.WriteString("</s>")
+		case nodeMono:
+			b.WriteString("<code>")
+			b.WriteString(html.EscapeString(n.text))
+			b.WriteString("</code>")
+		case nodeMention:
+			name := n.text
+			if jidToName != nil {
+				if resolved := jidToName(n.text); resolved != "" {
+					name = resolved
+				}
+			}
+			b.WriteString(`<a href="#" class="mention" data-jid="`)
+			b.WriteString(html.EscapeString(n.text))
+			b.WriteString(`">@`)
+			b.WriteString(html.EscapeString(name))
+			b.WriteString("</a>")
+		case nodeLink:
+			escaped := html.EscapeString(n.text)
+			b.WriteString(`<a href="`)
+			b.WriteString(escaped)
+			b.WriteString(`">`)
+			b.WriteString(escaped)
+			b.WriteString("</a>")
+		}
+	}
+}
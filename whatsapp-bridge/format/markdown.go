@@ -0,0 +1,45 @@
+package format
+
+import "strings"
+
+// ToMarkdown converts WhatsApp's inline formatting and @-mentions in text
+// into Markdown. mentions lists the JIDs the message actually mentions
+// (from ContextInfo.MentionedJID); only @<jid> runs found in mentions are
+// treated as mentions, everything else is left as plain text.
+func ToMarkdown(text string, mentions []string) string {
+	var b strings.Builder
+	renderMarkdown(&b, parse(text, mentions))
+	return b.String()
+}
+
+func renderMarkdown(b *strings.Builder, nodes []node) {
+	for _, n := range nodes {
+		switch n.kind {
+		case nodeText:
+			b.WriteString(n.text)
+		case nodeBold:
+			b.WriteString("**")
+			renderMarkdown(b, n.children)
+			b.WriteString("**")
+		case nodeItalic:
+			b.WriteString("*")
+			renderMarkdown(b, n.children)
+			b.WriteString("*")
+		case nodeStrike:
+			b.WriteString("~~")
+			renderMarkdown(b, n.children)
+			b.WriteString("~~")
+		case nodeMono:
+			b.WriteString("`")
+			b.WriteString(n.text)
+			b.WriteString("`")
+		case nodeMention:
+			b.WriteString("@")
+			b.WriteString(n.text)
+		case nodeLink:
+			b.WriteString("<")
+			b.WriteString(n.text)
+			b.WriteString(">")
+		}
+	}
+}
@@ -0,0 +1,553 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	"go.mau.fi/whatsmeow"
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	"go.mau.fi/whatsmeow/store/sqlstore"
+	"go.mau.fi/whatsmeow/types/events"
+	waLog "go.mau.fi/whatsmeow/util/log"
+
+	"whatsapp-bridge/format"
+)
+
+// Chat represents a single WhatsApp chat thread.
+type Chat struct {
+	JID             string
+	Name            string
+	LastMessageTime time.Time
+}
+
+// Message represents a single stored WhatsApp message.
+type Message struct {
+	Sender          string
+	Content         string
+	Timestamp       time.Time
+	IsFromMe        bool
+	MediaType       string
+	Filename        string
+	LocalURI        string
+	QuotedMessageID string
+	QuotedSender    string
+	MentionedJIDs   []string
+	AlbumID         string
+	// Children holds the other messages sharing this message's AlbumID
+	// when GetMessages is called with CollapseAlbums; empty otherwise.
+	Children []Message
+	// ContentHTML holds Content rendered to HTML via the format package
+	// when GetMessages is called with GetMessagesOptions.RenderHTML; empty
+	// otherwise.
+	ContentHTML string
+}
+
+// MessageStore persists chats and messages to a local SQLite database.
+type MessageStore struct {
+	db *sql.DB
+	// ftsAvailable reports whether messages_fts was created successfully;
+	// when false, SearchMessages falls back to a LIKE-based query.
+	ftsAvailable bool
+}
+
+// NewMessageStore opens (or creates) the schema needed to store chats and
+// messages and returns a MessageStore backed by db.
+func NewMessageStore(db *sql.DB) (*MessageStore, error) {
+	if _, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS chats (
+			jid TEXT PRIMARY KEY,
+			name TEXT,
+			last_message_time TIMESTAMP
+		);
+		CREATE TABLE IF NOT EXISTS messages (
+			id TEXT,
+			chat_jid TEXT,
+			sender TEXT,
+			content TEXT,
+			timestamp TIMESTAMP,
+			is_from_me BOOLEAN,
+			media_type TEXT,
+			filename TEXT,
+			url TEXT,
+			media_key BLOB,
+			file_sha256 BLOB,
+			file_enc_sha256 BLOB,
+			file_length INTEGER,
+			local_uri TEXT,
+			PRIMARY KEY (id, chat_jid),
+			FOREIGN KEY (chat_jid) REFERENCES chats(jid)
+		);
+	`); err != nil {
+		return nil, fmt.Errorf("failed to create tables: %w", err)
+	}
+
+	if err := migrateMessagesTable(db); err != nil {
+		return nil, fmt.Errorf("failed to migrate messages table: %w", err)
+	}
+
+	store := &MessageStore{db: db}
+	store.ensureSearchIndex()
+	return store, nil
+}
+
+// migrateMessagesTable adds columns introduced after the original schema.
+// SQLite has no "ADD COLUMN IF NOT EXISTS", so each ALTER is attempted and
+// a "duplicate column" failure (the column already exists) is swallowed.
+func migrateMessagesTable(db *sql.DB) error {
+	columns := []string{
+		"ALTER TABLE messages ADD COLUMN quoted_message_id TEXT",
+		"ALTER TABLE messages ADD COLUMN quoted_sender TEXT",
+		"ALTER TABLE messages ADD COLUMN mentioned_jids TEXT",
+		"ALTER TABLE messages ADD COLUMN album_id TEXT",
+	}
+	for _, stmt := range columns {
+		if _, err := db.Exec(stmt); err != nil && !strings.Contains(err.Error(), "duplicate column") {
+			return err
+		}
+	}
+	return nil
+}
+
+// StoreChat upserts a chat's metadata.
+func (store *MessageStore) StoreChat(jid, name string, lastMessageTime time.Time) error {
+	_, err := store.db.Exec(
+		`INSERT OR REPLACE INTO chats (jid, name, last_message_time) VALUES (?, ?, ?)`,
+		jid, name, lastMessageTime,
+	)
+	return err
+}
+
+// StoreMessage upserts a single message, including any media metadata and
+// the reply/mention context extracted by extractMessageContext.
+func (store *MessageStore) StoreMessage(
+	id, chatJID, sender, content string,
+	timestamp time.Time,
+	isFromMe bool,
+	mediaType, filename, url string,
+	mediaKey, fileSHA256, fileEncSHA256 []byte,
+	fileLength uint64,
+	quotedMessageID, quotedSender string,
+	mentionedJIDs []string,
+	albumID string,
+) error {
+	if content == "" && mediaType == "" {
+		return nil
+	}
+
+	mentionedJIDsJSON, err := json.Marshal(mentionedJIDs)
+	if err != nil {
+		return fmt.Errorf("failed to marshal mentioned JIDs: %w", err)
+	}
+
+	_, err = store.db.Exec(
+		`INSERT OR REPLACE INTO messages
+			(id, chat_jid, sender, content, timestamp, is_from_me, media_type, filename, url, media_key, file_sha256, file_enc_sha256, file_length, quoted_message_id, quoted_sender, mentioned_jids, album_id)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		id, chatJID, sender, content, timestamp, isFromMe, mediaType, filename, url, mediaKey, fileSHA256, fileEncSHA256, fileLength, quotedMessageID, quotedSender, string(mentionedJIDsJSON), albumID,
+	)
+	return err
+}
+
+// SetMessageLocalURI records where a message's downloaded media was
+// persisted by the configured MediaStore.
+func (store *MessageStore) SetMessageLocalURI(messageID, chatJID, localURI string) error {
+	_, err := store.db.Exec(
+		`UPDATE messages SET local_uri = ? WHERE id = ? AND chat_jid = ?`,
+		localURI, messageID, chatJID,
+	)
+	return err
+}
+
+// messageColumns lists the columns scanMessage expects, in order, shared by
+// every query that reads back full Message rows.
+const messageColumns = "sender, content, timestamp, is_from_me, media_type, filename, local_uri, quoted_message_id, quoted_sender, mentioned_jids, album_id"
+
+// GetMessagesOptions configures optional post-processing for GetMessages.
+type GetMessagesOptions struct {
+	// CollapseAlbums groups album siblings under the first message's
+	// Children instead of returning each as its own top-level entry.
+	CollapseAlbums bool
+	// RenderHTML populates each returned Message's ContentHTML with Content
+	// rendered via format.ToHTML. JIDToName is passed through to resolve
+	// @-mentions to display names; it may be nil.
+	RenderHTML bool
+	JIDToName  func(jid string) string
+}
+
+// GetMessages returns up to limit messages for chatJID, most recent first.
+func (store *MessageStore) GetMessages(chatJID string, limit int, opts GetMessagesOptions) ([]Message, error) {
+	rows, err := store.db.Query(
+		`SELECT `+messageColumns+` FROM messages
+		 WHERE chat_jid = ?
+		 ORDER BY timestamp DESC
+		 LIMIT ?`,
+		chatJID, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages []Message
+	for rows.Next() {
+		msg, err := scanMessage(rows)
+		if err != nil {
+			return nil, err
+		}
+		if opts.RenderHTML {
+			msg.ContentHTML = format.ToHTML(msg.Content, msg.MentionedJIDs, opts.JIDToName)
+		}
+		messages = append(messages, msg)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if opts.CollapseAlbums {
+		messages = collapseAlbums(messages)
+	}
+	return messages, nil
+}
+
+// collapseAlbums groups messages sharing a non-empty AlbumID, whether or not
+// they are adjacent, keeping the first as the parent and moving the rest into
+// its Children.
+func collapseAlbums(messages []Message) []Message {
+	byAlbum := make(map[string]int, len(messages))
+	collapsed := make([]Message, 0, len(messages))
+
+	for _, msg := range messages {
+		if msg.AlbumID == "" {
+			collapsed = append(collapsed, msg)
+			continue
+		}
+
+		if parentIdx, ok := byAlbum[msg.AlbumID]; ok {
+			collapsed[parentIdx].Children = append(collapsed[parentIdx].Children, msg)
+			continue
+		}
+
+		byAlbum[msg.AlbumID] = len(collapsed)
+		collapsed = append(collapsed, msg)
+	}
+
+	return collapsed
+}
+
+// scanMessage scans a row produced by a query selecting messageColumns into
+// a Message.
+func scanMessage(rows *sql.Rows) (Message, error) {
+	var msg Message
+	var localURI, quotedMessageID, quotedSender, mentionedJIDsJSON, albumID sql.NullString
+	if err := rows.Scan(&msg.Sender, &msg.Content, &msg.Timestamp, &msg.IsFromMe, &msg.MediaType, &msg.Filename, &localURI, &quotedMessageID, &quotedSender, &mentionedJIDsJSON, &albumID); err != nil {
+		return Message{}, err
+	}
+	msg.LocalURI = localURI.String
+	msg.QuotedMessageID = quotedMessageID.String
+	msg.QuotedSender = quotedSender.String
+	msg.AlbumID = albumID.String
+	if mentionedJIDsJSON.String != "" {
+		if err := json.Unmarshal([]byte(mentionedJIDsJSON.String), &msg.MentionedJIDs); err != nil {
+			return Message{}, fmt.Errorf("failed to unmarshal mentioned JIDs: %w", err)
+		}
+	}
+	return msg, nil
+}
+
+// GetAlbumMessages returns every message sharing albumID in chatJID, ordered
+// by timestamp. An empty albumID short-circuits to (nil, nil) so a NULL or
+// unset album id never fans out to unrelated messages.
+func (store *MessageStore) GetAlbumMessages(chatJID, albumID string) ([]Message, error) {
+	if albumID == "" {
+		return nil, nil
+	}
+
+	rows, err := store.db.Query(
+		`SELECT `+messageColumns+` FROM messages
+		 WHERE chat_jid = ? AND album_id = ?
+		 ORDER BY timestamp ASC`,
+		chatJID, albumID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages []Message
+	for rows.Next() {
+		msg, err := scanMessage(rows)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, msg)
+	}
+	return messages, rows.Err()
+}
+
+// GetMessageThread walks the quote chain starting at (chatJID, messageID),
+// following QuotedMessageID up to maxDepth levels, and returns the messages
+// from the original one down to the starting message.
+func (store *MessageStore) GetMessageThread(chatJID, messageID string, maxDepth int) ([]Message, error) {
+	var thread []Message
+	currentID := messageID
+
+	for i := 0; i < maxDepth && currentID != ""; i++ {
+		rows, err := store.db.Query(
+			`SELECT `+messageColumns+` FROM messages
+			 WHERE chat_jid = ? AND id = ?`,
+			chatJID, currentID,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		if !rows.Next() {
+			rows.Close()
+			break
+		}
+		msg, err := scanMessage(rows)
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		thread = append([]Message{msg}, thread...)
+		currentID = msg.QuotedMessageID
+	}
+
+	return thread, nil
+}
+
+// GetChats returns all known chats.
+func (store *MessageStore) GetChats() ([]Chat, error) {
+	rows, err := store.db.Query(`SELECT jid, last_message_time FROM chats ORDER BY last_message_time DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var chats []Chat
+	for rows.Next() {
+		var chat Chat
+		if err := rows.Scan(&chat.JID, &chat.LastMessageTime); err != nil {
+			return nil, err
+		}
+		chats = append(chats, chat)
+	}
+	return chats, rows.Err()
+}
+
+// extractTextContent pulls the plain-text body out of a WhatsApp message,
+// regardless of whether it arrived as a plain conversation or an extended
+// text message.
+func extractTextContent(msg *waProto.Message) string {
+	if msg == nil {
+		return ""
+	}
+
+	if text := msg.GetConversation(); text != "" {
+		return text
+	}
+
+	if extendedText := msg.GetExtendedTextMessage(); extendedText != nil {
+		return extendedText.GetText()
+	}
+
+	return ""
+}
+
+// extractMediaInfo extracts the media metadata needed to later download and
+// verify a media attachment, if msg carries one. directPath is the CDN path
+// WhatsApp attached to the submessage itself; it's what DownloadMediaWithPath
+// needs and is used as-is, never derived from url.
+func extractMediaInfo(msg *waProto.Message) (mediaType, filename, url, directPath string, mediaKey, fileSHA256, fileEncSHA256 []byte, fileLength uint64) {
+	if msg == nil {
+		return "", "", "", "", nil, nil, nil, 0
+	}
+
+	switch {
+	case msg.GetImageMessage() != nil:
+		img := msg.GetImageMessage()
+		return "image", "", img.GetURL(), img.GetDirectPath(), img.GetMediaKey(), img.GetFileSHA256(), img.GetFileEncSHA256(), img.GetFileLength()
+	case msg.GetVideoMessage() != nil:
+		vid := msg.GetVideoMessage()
+		return "video", "", vid.GetURL(), vid.GetDirectPath(), vid.GetMediaKey(), vid.GetFileSHA256(), vid.GetFileEncSHA256(), vid.GetFileLength()
+	case msg.GetAudioMessage() != nil:
+		aud := msg.GetAudioMessage()
+		return "audio", "", aud.GetURL(), aud.GetDirectPath(), aud.GetMediaKey(), aud.GetFileSHA256(), aud.GetFileEncSHA256(), aud.GetFileLength()
+	case msg.GetDocumentMessage() != nil:
+		doc := msg.GetDocumentMessage()
+		return "document", doc.GetFileName(), doc.GetURL(), doc.GetDirectPath(), doc.GetMediaKey(), doc.GetFileSHA256(), doc.GetFileEncSHA256(), doc.GetFileLength()
+	default:
+		return "", "", "", "", nil, nil, nil, 0
+	}
+}
+
+// extractMessageContext pulls the reply/quote and @-mention metadata out of
+// a message's ContextInfo, if it carries one. quotedText is only available
+// when the quoted message was embedded inline by the client that sent the
+// reply; StoreMessage does not persist it since it's redundant with the
+// quoted message's own row.
+func extractMessageContext(msg *waProto.Message) (quotedMessageID, quotedSender, quotedText string, mentionedJIDs []string) {
+	ctxInfo := messageContextInfo(msg)
+	if ctxInfo == nil {
+		return "", "", "", nil
+	}
+
+	quotedMessageID = ctxInfo.GetStanzaID()
+	quotedSender = ctxInfo.GetParticipant()
+	mentionedJIDs = ctxInfo.GetMentionedJID()
+	if quoted := ctxInfo.GetQuotedMessage(); quoted != nil {
+		quotedText = extractTextContent(quoted)
+	}
+	return quotedMessageID, quotedSender, quotedText, mentionedJIDs
+}
+
+// extractAlbumID returns the shared album id WhatsApp attaches to the
+// top-level MessageContextInfo of every image/video that belongs to the
+// same album, or "" if msg isn't part of one. Unlike the reply/mention
+// metadata in extractMessageContext, MessageAssociation lives on the
+// message itself rather than on the per-submessage ContextInfo.
+func extractAlbumID(msg *waProto.Message) string {
+	if msg == nil {
+		return ""
+	}
+
+	association := msg.GetMessageContextInfo().GetMessageAssociation()
+	if association == nil || association.GetAssociationType() != waE2E.MessageAssociation_MEDIA_ALBUM {
+		return ""
+	}
+
+	return association.GetParentMessageKey().GetID()
+}
+
+// messageContextInfo returns the ContextInfo carried by whichever message
+// type msg actually is, or nil if it has none.
+func messageContextInfo(msg *waProto.Message) *waProto.ContextInfo {
+	if msg == nil {
+		return nil
+	}
+
+	switch {
+	case msg.GetExtendedTextMessage() != nil:
+		return msg.GetExtendedTextMessage().GetContextInfo()
+	case msg.GetImageMessage() != nil:
+		return msg.GetImageMessage().GetContextInfo()
+	case msg.GetVideoMessage() != nil:
+		return msg.GetVideoMessage().GetContextInfo()
+	case msg.GetDocumentMessage() != nil:
+		return msg.GetDocumentMessage().GetContextInfo()
+	default:
+		return nil
+	}
+}
+
+func eventHandler(client *whatsmeow.Client, store *MessageStore, mediaWorkers *MediaWorkerPool) func(interface{}) {
+	return func(rawEvt interface{}) {
+		switch evt := rawEvt.(type) {
+		case *events.Message:
+			handleMessage(client, store, mediaWorkers, evt)
+		}
+	}
+}
+
+func handleMessage(client *whatsmeow.Client, store *MessageStore, mediaWorkers *MediaWorkerPool, evt *events.Message) {
+	chatJID := evt.Info.Chat.String()
+	sender := evt.Info.Sender.String()
+	content := extractTextContent(evt.Message)
+	mediaType, filename, url, directPath, mediaKey, fileSHA256, fileEncSHA256, fileLength := extractMediaInfo(evt.Message)
+	quotedMessageID, quotedSender, _, mentionedJIDs := extractMessageContext(evt.Message)
+	albumID := extractAlbumID(evt.Message)
+
+	if err := store.StoreMessage(
+		evt.Info.ID, chatJID, sender, content, evt.Info.Timestamp, evt.Info.IsFromMe,
+		mediaType, filename, url, mediaKey, fileSHA256, fileEncSHA256, fileLength,
+		quotedMessageID, quotedSender, mentionedJIDs, albumID,
+	); err != nil {
+		log.Printf("failed to store message %s: %v", evt.Info.ID, err)
+		return
+	}
+
+	if mediaType != "" {
+		messageID := evt.Info.ID
+		if err := mediaWorkers.Submit(func(ctx context.Context) error {
+			return downloadAndStoreMedia(ctx, client, store, chatJID, messageID, mediaType, mediaKey, fileSHA256, fileEncSHA256, directPath)
+		}); err != nil {
+			log.Printf("failed to submit media download for message %s: %v", messageID, err)
+		}
+	}
+}
+
+func main() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dbLog := waLog.Stdout("Database", "INFO", true)
+	container, err := sqlstore.New(ctx, "sqlite3", "file:whatsapp.db?_foreign_keys=on", dbLog)
+	if err != nil {
+		log.Fatalf("failed to connect to device store: %v", err)
+	}
+
+	deviceStore, err := container.GetFirstDevice(ctx)
+	if err != nil {
+		log.Fatalf("failed to get device: %v", err)
+	}
+
+	messagesDB, err := sql.Open("sqlite3", "file:store/messages.db?_foreign_keys=on&_recursive_triggers=1")
+	if err != nil {
+		log.Fatalf("failed to open messages database: %v", err)
+	}
+	defer messagesDB.Close()
+
+	messageStore, err := NewMessageStore(messagesDB)
+	if err != nil {
+		log.Fatalf("failed to initialize message store: %v", err)
+	}
+
+	if err := configureMediaStore(ctx); err != nil {
+		log.Fatalf("failed to configure media store: %v", err)
+	}
+
+	mediaWorkers := NewMediaWorkerPool(DefaultWorkerPoolConfig())
+	mediaWorkers.Run(ctx)
+
+	clientLog := waLog.Stdout("Client", "INFO", true)
+	client := whatsmeow.NewClient(deviceStore, clientLog)
+	client.AddEventHandler(eventHandler(client, messageStore, mediaWorkers))
+
+	if client.Store.ID == nil {
+		qrChan, _ := client.GetQRChannel(ctx)
+		if err := client.Connect(); err != nil {
+			log.Fatalf("failed to connect: %v", err)
+		}
+		for evt := range qrChan {
+			if evt.Event == "code" {
+				fmt.Println("Scan the QR code to log in:", evt.Code)
+			}
+		}
+	} else if err := client.Connect(); err != nil {
+		log.Fatalf("failed to connect: %v", err)
+	}
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	<-sigChan
+
+	client.Disconnect()
+
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer shutdownCancel()
+	if err := mediaWorkers.Shutdown(shutdownCtx); err != nil {
+		log.Printf("media worker pool did not drain cleanly: %v", err)
+	}
+}
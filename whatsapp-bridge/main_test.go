@@ -1,6 +1,7 @@
 package main
 
 import (
+	"fmt"
 	"testing"
 	"time"
 
@@ -8,19 +9,50 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/proto/waE2E"
 	"google.golang.org/protobuf/proto"
 )
 
+// messageTestColumns mirrors messageColumns for building sqlmock rows.
+var messageTestColumns = []string{
+	"sender", "content", "timestamp", "is_from_me", "media_type", "filename",
+	"local_uri", "quoted_message_id", "quoted_sender", "mentioned_jids", "album_id",
+}
+
 func TestNewMessageStore(t *testing.T) {
 	db, mock, err := sqlmock.New()
 	require.NoError(t, err)
 	defer db.Close()
 
 	mock.ExpectExec("CREATE TABLE IF NOT EXISTS chats").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("ALTER TABLE messages ADD COLUMN quoted_message_id").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("ALTER TABLE messages ADD COLUMN quoted_sender").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("ALTER TABLE messages ADD COLUMN mentioned_jids").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("ALTER TABLE messages ADD COLUMN album_id").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("CREATE VIRTUAL TABLE IF NOT EXISTS messages_fts").WillReturnResult(sqlmock.NewResult(0, 0))
 
 	store, err := NewMessageStore(db)
 	require.NoError(t, err)
 	assert.NotNil(t, store)
+	assert.True(t, store.ftsAvailable)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestNewMessageStoreFallsBackWithoutFTS5(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectExec("CREATE TABLE IF NOT EXISTS chats").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("ALTER TABLE messages ADD COLUMN quoted_message_id").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("ALTER TABLE messages ADD COLUMN quoted_sender").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("ALTER TABLE messages ADD COLUMN mentioned_jids").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("ALTER TABLE messages ADD COLUMN album_id").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("CREATE VIRTUAL TABLE IF NOT EXISTS messages_fts").WillReturnError(fmt.Errorf("no such module: fts5"))
+
+	store, err := NewMessageStore(db)
+	require.NoError(t, err)
+	assert.False(t, store.ftsAvailable)
 	assert.NoError(t, mock.ExpectationsWereMet())
 }
 
@@ -46,10 +78,10 @@ func TestStoreMessage(t *testing.T) {
 
 	store := &MessageStore{db: db}
 	mock.ExpectExec("INSERT OR REPLACE INTO messages").
-		WithArgs("id", "chatJID", "sender", "content", sqlmock.AnyArg(), false, "mediaType", "filename", "url", []byte("mediaKey"), []byte("fileSHA256"), []byte("fileEncSHA256"), uint64(123)).
+		WithArgs("id", "chatJID", "sender", "content", sqlmock.AnyArg(), false, "mediaType", "filename", "url", []byte("mediaKey"), []byte("fileSHA256"), []byte("fileEncSHA256"), uint64(123), "quotedID", "quotedSender", `["1234@s.whatsapp.net"]`, "albumID").
 		WillReturnResult(sqlmock.NewResult(1, 1))
 
-	err = store.StoreMessage("id", "chatJID", "sender", "content", time.Now(), false, "mediaType", "filename", "url", []byte("mediaKey"), []byte("fileSHA256"), []byte("fileEncSHA256"), uint64(123))
+	err = store.StoreMessage("id", "chatJID", "sender", "content", time.Now(), false, "mediaType", "filename", "url", []byte("mediaKey"), []byte("fileSHA256"), []byte("fileEncSHA256"), uint64(123), "quotedID", "quotedSender", []string{"1234@s.whatsapp.net"}, "albumID")
 	require.NoError(t, err)
 	assert.NoError(t, mock.ExpectationsWereMet())
 }
@@ -60,16 +92,117 @@ func TestGetMessages(t *testing.T) {
 	defer db.Close()
 
 	store := &MessageStore{db: db}
-	rows := sqlmock.NewRows([]string{"sender", "content", "timestamp", "is_from_me", "media_type", "filename"}).
-		AddRow("sender", "content", time.Now(), false, "mediaType", "filename")
+	rows := sqlmock.NewRows(messageTestColumns).
+		AddRow("sender", "content", time.Now(), false, "mediaType", "filename", "file:///tmp/foo.jpg", "quotedID", "quotedSender", `["1234@s.whatsapp.net"]`, "")
 
-	mock.ExpectQuery("SELECT sender, content, timestamp, is_from_me, media_type, filename FROM messages").
+	mock.ExpectQuery("SELECT " + messageColumns + " FROM messages").
 		WithArgs("chatJID", 10).
 		WillReturnRows(rows)
 
-	messages, err := store.GetMessages("chatJID", 10)
+	messages, err := store.GetMessages("chatJID", 10, GetMessagesOptions{})
 	require.NoError(t, err)
 	assert.Len(t, messages, 1)
+	assert.Equal(t, "file:///tmp/foo.jpg", messages[0].LocalURI)
+	assert.Equal(t, "quotedID", messages[0].QuotedMessageID)
+	assert.Equal(t, []string{"1234@s.whatsapp.net"}, messages[0].MentionedJIDs)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetMessagesRendersHTML(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	store := &MessageStore{db: db}
+	rows := sqlmock.NewRows(messageTestColumns).
+		AddRow("sender", "hi *there* @12345", time.Now(), false, "", "", "", "", "", `["12345"]`, "")
+
+	mock.ExpectQuery("SELECT " + messageColumns + " FROM messages").
+		WithArgs("chatJID", 10).
+		WillReturnRows(rows)
+
+	messages, err := store.GetMessages("chatJID", 10, GetMessagesOptions{
+		RenderHTML: true,
+		JIDToName:  func(jid string) string { return "Alice" },
+	})
+	require.NoError(t, err)
+	require.Len(t, messages, 1)
+	assert.Equal(t, "hi <b>there</b> <a href=\"#\" class=\"mention\" data-jid=\"12345\">@Alice</a>", messages[0].ContentHTML)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetMessagesCollapsesAlbums(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	store := &MessageStore{db: db}
+	now := time.Now()
+	rows := sqlmock.NewRows(messageTestColumns).
+		AddRow("sender", "caption", now, false, "image", "1.jpg", "", "", "", "", "album-1").
+		AddRow("sender", "", now, false, "image", "2.jpg", "", "", "", "", "album-1").
+		AddRow("sender", "hi", now, false, "", "", "", "", "", "", "")
+
+	mock.ExpectQuery("SELECT " + messageColumns + " FROM messages").
+		WithArgs("chatJID", 10).
+		WillReturnRows(rows)
+
+	messages, err := store.GetMessages("chatJID", 10, GetMessagesOptions{CollapseAlbums: true})
+	require.NoError(t, err)
+	require.Len(t, messages, 2)
+	assert.Equal(t, "album-1", messages[0].AlbumID)
+	require.Len(t, messages[0].Children, 1)
+	assert.Equal(t, "2.jpg", messages[0].Children[0].Filename)
+	assert.Empty(t, messages[1].AlbumID)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetAlbumMessages(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	store := &MessageStore{db: db}
+
+	// An empty albumID must short-circuit without touching the database.
+	messages, err := store.GetAlbumMessages("chatJID", "")
+	require.NoError(t, err)
+	assert.Nil(t, messages)
+
+	rows := sqlmock.NewRows(messageTestColumns).
+		AddRow("sender", "", time.Now(), false, "image", "1.jpg", "", "", "", "", "album-1")
+
+	mock.ExpectQuery("SELECT " + messageColumns + " FROM messages").
+		WithArgs("chatJID", "album-1").
+		WillReturnRows(rows)
+
+	messages, err = store.GetAlbumMessages("chatJID", "album-1")
+	require.NoError(t, err)
+	require.Len(t, messages, 1)
+	assert.Equal(t, "album-1", messages[0].AlbumID)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetMessageThread(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	store := &MessageStore{db: db}
+
+	mock.ExpectQuery("SELECT " + messageColumns + " FROM messages").
+		WithArgs("chatJID", "reply-id").
+		WillReturnRows(sqlmock.NewRows(messageTestColumns).AddRow("bob", "sure thing", time.Now(), false, "", "", "", "orig-id", "alice", "", ""))
+
+	mock.ExpectQuery("SELECT " + messageColumns + " FROM messages").
+		WithArgs("chatJID", "orig-id").
+		WillReturnRows(sqlmock.NewRows(messageTestColumns).AddRow("alice", "can you send the file?", time.Now(), false, "", "", "", "", "", "", ""))
+
+	thread, err := store.GetMessageThread("chatJID", "reply-id", 5)
+	require.NoError(t, err)
+	require.Len(t, thread, 2)
+	assert.Equal(t, "alice", thread[0].Sender)
+	assert.Equal(t, "bob", thread[1].Sender)
 	assert.NoError(t, mock.ExpectationsWereMet())
 }
 
@@ -110,45 +243,101 @@ func TestExtractTextContent(t *testing.T) {
 
 func TestExtractMediaInfo(t *testing.T) {
 	// Test with image message
-	imgMsg := &waProto.Message{ImageMessage: &waProto.ImageMessage{URL: proto.String("img_url")}}
-	mediaType, _, url, _, _, _, _ := extractMediaInfo(imgMsg)
+	imgMsg := &waProto.Message{ImageMessage: &waProto.ImageMessage{URL: proto.String("img_url"), DirectPath: proto.String("/v/img_path")}}
+	mediaType, _, url, directPath, _, _, _, _ := extractMediaInfo(imgMsg)
 	assert.Equal(t, "image", mediaType)
 	assert.Equal(t, "img_url", url)
+	assert.Equal(t, "/v/img_path", directPath)
 
 	// Test with video message
-	vidMsg := &waProto.Message{VideoMessage: &waProto.VideoMessage{URL: proto.String("vid_url")}}
-	mediaType, _, url, _, _, _, _ = extractMediaInfo(vidMsg)
+	vidMsg := &waProto.Message{VideoMessage: &waProto.VideoMessage{URL: proto.String("vid_url"), DirectPath: proto.String("/v/vid_path")}}
+	mediaType, _, url, directPath, _, _, _, _ = extractMediaInfo(vidMsg)
 	assert.Equal(t, "video", mediaType)
 	assert.Equal(t, "vid_url", url)
+	assert.Equal(t, "/v/vid_path", directPath)
 
 	// Test with audio message
-	audMsg := &waProto.Message{AudioMessage: &waProto.AudioMessage{URL: proto.String("aud_url")}}
-	mediaType, _, url, _, _, _, _ = extractMediaInfo(audMsg)
+	audMsg := &waProto.Message{AudioMessage: &waProto.AudioMessage{URL: proto.String("aud_url"), DirectPath: proto.String("/v/aud_path")}}
+	mediaType, _, url, directPath, _, _, _, _ = extractMediaInfo(audMsg)
 	assert.Equal(t, "audio", mediaType)
 	assert.Equal(t, "aud_url", url)
+	assert.Equal(t, "/v/aud_path", directPath)
 
 	// Test with document message
-	docMsg := &waProto.Message{DocumentMessage: &waProto.DocumentMessage{URL: proto.String("doc_url"), FileName: proto.String("doc.pdf")}}
-	mediaType, filename, url, _, _, _, _ := extractMediaInfo(docMsg)
+	docMsg := &waProto.Message{DocumentMessage: &waProto.DocumentMessage{URL: proto.String("doc_url"), FileName: proto.String("doc.pdf"), DirectPath: proto.String("/v/doc_path")}}
+	mediaType, filename, url, directPath, _, _, _, _ := extractMediaInfo(docMsg)
 	assert.Equal(t, "document", mediaType)
 	assert.Equal(t, "doc.pdf", filename)
 	assert.Equal(t, "doc_url", url)
+	assert.Equal(t, "/v/doc_path", directPath)
 
 	// Test with no media
 	noMediaMsg := &waProto.Message{}
-	mediaType, _, _, _, _, _, _ = extractMediaInfo(noMediaMsg)
+	mediaType, _, _, _, _, _, _, _ = extractMediaInfo(noMediaMsg)
 	assert.Equal(t, "", mediaType)
 
 	// Test with nil message
-	mediaType, _, _, _, _, _, _ = extractMediaInfo(nil)
+	mediaType, _, _, _, _, _, _, _ = extractMediaInfo(nil)
 	assert.Equal(t, "", mediaType)
 }
 
-func TestExtractDirectPathFromURL(t *testing.T) {
-	url := "https://mmg.whatsapp.net/v/t62.7118-24/13812002_698058036224062_3424455886509161511_n.enc?ccb=11-4&oh=..."
-	expected := "/v/t62.7118-24/13812002_698058036224062_3424455886509161511_n.enc"
-	assert.Equal(t, expected, extractDirectPathFromURL(url))
+func TestExtractMessageContext(t *testing.T) {
+	// Test with a reply that mentions someone
+	ctxInfo := &waProto.ContextInfo{
+		StanzaID:      proto.String("orig-id"),
+		Participant:   proto.String("alice@s.whatsapp.net"),
+		MentionedJID:  []string{"bob@s.whatsapp.net"},
+		QuotedMessage: &waProto.Message{Conversation: proto.String("original text")},
+	}
+	msg := &waProto.Message{ExtendedTextMessage: &waProto.ExtendedTextMessage{Text: proto.String("reply"), ContextInfo: ctxInfo}}
+
+	quotedMessageID, quotedSender, quotedText, mentionedJIDs := extractMessageContext(msg)
+	assert.Equal(t, "orig-id", quotedMessageID)
+	assert.Equal(t, "alice@s.whatsapp.net", quotedSender)
+	assert.Equal(t, "original text", quotedText)
+	assert.Equal(t, []string{"bob@s.whatsapp.net"}, mentionedJIDs)
+
+	// Test image message carrying context info
+	imgMsg := &waProto.Message{ImageMessage: &waProto.ImageMessage{URL: proto.String("img_url"), ContextInfo: ctxInfo}}
+	quotedMessageID, _, _, _ = extractMessageContext(imgMsg)
+	assert.Equal(t, "orig-id", quotedMessageID)
+
+	// Test video message carrying context info
+	vidMsg := &waProto.Message{VideoMessage: &waProto.VideoMessage{URL: proto.String("vid_url"), ContextInfo: ctxInfo}}
+	quotedMessageID, _, _, _ = extractMessageContext(vidMsg)
+	assert.Equal(t, "orig-id", quotedMessageID)
+
+	// Test document message carrying context info
+	docMsg := &waProto.Message{DocumentMessage: &waProto.DocumentMessage{URL: proto.String("doc_url"), ContextInfo: ctxInfo}}
+	quotedMessageID, _, _, _ = extractMessageContext(docMsg)
+	assert.Equal(t, "orig-id", quotedMessageID)
+
+	// Test with no context info
+	assert.Equal(t, "", func() string { id, _, _, _ := extractMessageContext(&waProto.Message{}); return id }())
+
+	// Test with nil message
+	quotedMessageID, quotedSender, quotedText, mentionedJIDs = extractMessageContext(nil)
+	assert.Equal(t, "", quotedMessageID)
+	assert.Equal(t, "", quotedSender)
+	assert.Equal(t, "", quotedText)
+	assert.Nil(t, mentionedJIDs)
+}
+
+func TestExtractAlbumID(t *testing.T) {
+	albumMsgCtxInfo := &waProto.MessageContextInfo{
+		MessageAssociation: &waE2E.MessageAssociation{
+			AssociationType:  waE2E.MessageAssociation_MEDIA_ALBUM.Enum(),
+			ParentMessageKey: &waProto.MessageKey{ID: proto.String("album-root-id")},
+		},
+	}
+	imgMsg := &waProto.Message{ImageMessage: &waProto.ImageMessage{URL: proto.String("img_url")}, MessageContextInfo: albumMsgCtxInfo}
+	assert.Equal(t, "album-root-id", extractAlbumID(imgMsg))
+
+	// A reply is not an album association, so it should be ignored.
+	replyCtx := &waProto.ContextInfo{StanzaID: proto.String("orig-id")}
+	replyMsg := &waProto.Message{ImageMessage: &waProto.ImageMessage{URL: proto.String("img_url"), ContextInfo: replyCtx}}
+	assert.Equal(t, "", extractAlbumID(replyMsg))
 
-	url = "invalid_url"
-	assert.Equal(t, "invalid_url", extractDirectPathFromURL(url))
+	assert.Equal(t, "", extractAlbumID(&waProto.Message{}))
+	assert.Equal(t, "", extractAlbumID(nil))
 }
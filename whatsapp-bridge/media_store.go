@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"go.mau.fi/whatsmeow"
+)
+
+// mediaStore is the backend media downloads are persisted through, selected
+// in main() based on the MEDIA_STORE_BACKEND environment variable.
+var mediaStore MediaStore
+
+// mediaTypes maps the media type strings extractMediaInfo returns onto the
+// whatsmeow media-type constants Download needs.
+var mediaTypes = map[string]whatsmeow.MediaType{
+	"image":    whatsmeow.MediaImage,
+	"video":    whatsmeow.MediaVideo,
+	"audio":    whatsmeow.MediaAudio,
+	"document": whatsmeow.MediaDocument,
+}
+
+// MediaStore persists a downloaded media blob for a given message and
+// returns a URI the blob can later be retrieved from.
+type MediaStore interface {
+	Put(ctx context.Context, chatJID, messageID, mediaType string, r io.Reader) (localURI string, err error)
+}
+
+// FSMediaStore stores media as plain files under a root directory, laid
+// out as <root>/<chatJID>/<messageID>.<mediaType>.
+type FSMediaStore struct {
+	root string
+}
+
+// NewFSMediaStore returns a MediaStore rooted at dir, creating it if
+// necessary.
+func NewFSMediaStore(dir string) (*FSMediaStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create media root %s: %w", dir, err)
+	}
+	return &FSMediaStore{root: dir}, nil
+}
+
+func (s *FSMediaStore) Put(ctx context.Context, chatJID, messageID, mediaType string, r io.Reader) (string, error) {
+	dir := filepath.Join(s.root, chatJID)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", fmt.Errorf("failed to create chat media dir: %w", err)
+	}
+
+	path := filepath.Join(dir, fmt.Sprintf("%s.%s", messageID, mediaType))
+	f, err := os.Create(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to create media file: %w", err)
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(f, r); err != nil {
+		return "", fmt.Errorf("failed to write media file: %w", err)
+	}
+
+	return "file://" + path, nil
+}
+
+// s3API is the subset of the S3 client used by S3MediaStore, narrowed so
+// tests can supply a fake.
+type s3API interface {
+	manager.UploadAPIClient
+}
+
+// S3MediaStore uploads media to an S3 bucket and returns either a plain
+// s3:// URI or, when signExpiry is non-zero, a pre-signed HTTPS URL.
+type S3MediaStore struct {
+	client     s3API
+	uploader   *manager.Uploader
+	presign    *s3.PresignClient
+	bucket     string
+	signExpiry time.Duration
+}
+
+// NewS3MediaStore builds an S3MediaStore for bucket in region, using
+// credentials from the environment (AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY,
+// AWS_SESSION_TOKEN, etc). If signExpiry is non-zero, Put returns a
+// pre-signed URL valid for that long instead of a bare s3:// URI.
+func NewS3MediaStore(ctx context.Context, bucket, region string, signExpiry time.Duration) (*S3MediaStore, error) {
+	cfg, err := config.LoadDefaultConfig(ctx, config.WithRegion(region))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load AWS config: %w", err)
+	}
+
+	client := s3.NewFromConfig(cfg)
+	return &S3MediaStore{
+		client:     client,
+		uploader:   manager.NewUploader(client),
+		presign:    s3.NewPresignClient(client),
+		bucket:     bucket,
+		signExpiry: signExpiry,
+	}, nil
+}
+
+func (s *S3MediaStore) Put(ctx context.Context, chatJID, messageID, mediaType string, r io.Reader) (string, error) {
+	key := fmt.Sprintf("%s/%s.%s", chatJID, messageID, mediaType)
+
+	if _, err := s.uploader.Upload(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+		Body:   r,
+	}); err != nil {
+		return "", fmt.Errorf("failed to upload media to s3: %w", err)
+	}
+
+	if s.signExpiry <= 0 {
+		return fmt.Sprintf("s3://%s/%s", s.bucket, key), nil
+	}
+
+	req, err := s.presign.PresignGetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	}, s3.WithPresignExpires(s.signExpiry))
+	if err != nil {
+		return "", fmt.Errorf("failed to presign media url: %w", err)
+	}
+
+	return req.URL, nil
+}
+
+// configureMediaStore picks the MediaStore backend from the environment:
+// MEDIA_STORE_BACKEND=s3 (with MEDIA_STORE_S3_BUCKET/_REGION and optionally
+// MEDIA_STORE_S3_SIGN_EXPIRY) or the default filesystem store rooted at
+// MEDIA_STORE_DIR (default "store/media").
+func configureMediaStore(ctx context.Context) error {
+	switch os.Getenv("MEDIA_STORE_BACKEND") {
+	case "s3":
+		bucket := os.Getenv("MEDIA_STORE_S3_BUCKET")
+		region := os.Getenv("MEDIA_STORE_S3_REGION")
+		if bucket == "" || region == "" {
+			return fmt.Errorf("MEDIA_STORE_S3_BUCKET and MEDIA_STORE_S3_REGION are required for the s3 media store")
+		}
+
+		var signExpiry time.Duration
+		if raw := os.Getenv("MEDIA_STORE_S3_SIGN_EXPIRY"); raw != "" {
+			d, err := time.ParseDuration(raw)
+			if err != nil {
+				return fmt.Errorf("invalid MEDIA_STORE_S3_SIGN_EXPIRY: %w", err)
+			}
+			signExpiry = d
+		}
+
+		store, err := NewS3MediaStore(ctx, bucket, region, signExpiry)
+		if err != nil {
+			return err
+		}
+		mediaStore = store
+	default:
+		dir := os.Getenv("MEDIA_STORE_DIR")
+		if dir == "" {
+			dir = "store/media"
+		}
+		store, err := NewFSMediaStore(dir)
+		if err != nil {
+			return err
+		}
+		mediaStore = store
+	}
+	return nil
+}
+
+// mediaDownloader is the subset of whatsmeow.Client used to fetch and
+// decrypt a media blob, narrowed so tests can supply a fake.
+type mediaDownloader interface {
+	DownloadMediaWithPath(ctx context.Context, directPath string, encFileHash, fileHash, mediaKey []byte, mediaType whatsmeow.MediaType, mmsType string, allowNoHash bool) ([]byte, error)
+}
+
+// downloadAndStoreMedia fetches the encrypted media blob at directPath,
+// decrypts and verifies it against fileSHA256, and persists the plaintext
+// through the configured mediaStore, writing the resulting URI back onto
+// the message row.
+func downloadAndStoreMedia(
+	ctx context.Context,
+	client mediaDownloader,
+	store *MessageStore,
+	chatJID, messageID, mediaType string,
+	mediaKey, fileSHA256, fileEncSHA256 []byte,
+	directPath string,
+) error {
+	if mediaStore == nil {
+		return fmt.Errorf("no media store configured")
+	}
+
+	waMediaType, ok := mediaTypes[mediaType]
+	if !ok {
+		return fmt.Errorf("unsupported media type %q", mediaType)
+	}
+
+	data, err := client.DownloadMediaWithPath(ctx, directPath, fileEncSHA256, fileSHA256, mediaKey, waMediaType, "", false)
+	if err != nil {
+		return fmt.Errorf("failed to download media: %w", err)
+	}
+
+	sum := sha256.Sum256(data)
+	if len(fileSHA256) > 0 && !bytes.Equal(sum[:], fileSHA256) {
+		return fmt.Errorf("media checksum mismatch for message %s", messageID)
+	}
+
+	localURI, err := mediaStore.Put(ctx, chatJID, messageID, mediaType, bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("failed to persist media: %w", err)
+	}
+
+	return store.SetMessageLocalURI(messageID, chatJID, localURI)
+}
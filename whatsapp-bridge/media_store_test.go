@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mau.fi/whatsmeow"
+)
+
+// fakeDownloader is an in-memory mediaDownloader used to exercise
+// downloadAndStoreMedia without hitting the WhatsApp CDN.
+type fakeDownloader struct {
+	data             []byte
+	err              error
+	calledDirectPath string
+}
+
+func (f *fakeDownloader) DownloadMediaWithPath(ctx context.Context, directPath string, encFileHash, fileHash, mediaKey []byte, mediaType whatsmeow.MediaType, mmsType string, allowNoHash bool) ([]byte, error) {
+	f.calledDirectPath = directPath
+	return f.data, f.err
+}
+
+// withMediaStore points the package-level mediaStore at store for the
+// duration of a test and restores it afterwards.
+func withMediaStore(t *testing.T, store MediaStore) {
+	t.Helper()
+	original := mediaStore
+	mediaStore = store
+	t.Cleanup(func() { mediaStore = original })
+}
+
+// fakeMediaStore is an in-memory MediaStore used by tests that only care
+// about what gets passed to Put, not real persistence.
+type fakeMediaStore struct {
+	puts      []fakePut
+	returnURI string
+}
+
+type fakePut struct {
+	chatJID, messageID, mediaType string
+	data                          []byte
+}
+
+func (f *fakeMediaStore) Put(ctx context.Context, chatJID, messageID, mediaType string, r io.Reader) (string, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return "", err
+	}
+	f.puts = append(f.puts, fakePut{chatJID, messageID, mediaType, data})
+	return f.returnURI, nil
+}
+
+func TestFSMediaStorePut(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewFSMediaStore(dir)
+	require.NoError(t, err)
+
+	uri, err := store.Put(context.Background(), "chatJID", "msgID", "image", bytes.NewReader([]byte("hello")))
+	require.NoError(t, err)
+	assert.Contains(t, uri, "chatJID")
+	assert.Contains(t, uri, "msgID.image")
+
+	data, err := os.ReadFile(filepath.Join(dir, "chatJID", "msgID.image"))
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(data))
+}
+
+func TestFakeMediaStoreRecordsPuts(t *testing.T) {
+	store := &fakeMediaStore{returnURI: "fake://stored"}
+
+	uri, err := store.Put(context.Background(), "chatJID", "msgID", "image", bytes.NewReader([]byte("data")))
+	require.NoError(t, err)
+	assert.Equal(t, "fake://stored", uri)
+	require.Len(t, store.puts, 1)
+	assert.Equal(t, "data", string(store.puts[0].data))
+}
+
+func TestDownloadAndStoreMediaHappyPath(t *testing.T) {
+	data := []byte("plaintext media")
+	sum := sha256.Sum256(data)
+	downloader := &fakeDownloader{data: data}
+	fakeStore := &fakeMediaStore{returnURI: "file:///tmp/msgID.image"}
+	withMediaStore(t, fakeStore)
+
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+	msgStore := &MessageStore{db: db}
+	mock.ExpectExec("UPDATE messages SET local_uri").
+		WithArgs("file:///tmp/msgID.image", "msgID", "chatJID").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	err = downloadAndStoreMedia(context.Background(), downloader, msgStore, "chatJID", "msgID", "image", nil, sum[:], nil, "/v/direct_path")
+	require.NoError(t, err)
+	assert.Equal(t, "/v/direct_path", downloader.calledDirectPath)
+	require.Len(t, fakeStore.puts, 1)
+	assert.Equal(t, data, fakeStore.puts[0].data)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDownloadAndStoreMediaChecksumMismatch(t *testing.T) {
+	downloader := &fakeDownloader{data: []byte("tampered")}
+	fakeStore := &fakeMediaStore{}
+	withMediaStore(t, fakeStore)
+
+	err := downloadAndStoreMedia(context.Background(), downloader, &MessageStore{}, "chatJID", "msgID", "image", nil, []byte("expected-hash"), nil, "/v/direct_path")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "checksum mismatch")
+	assert.Empty(t, fakeStore.puts)
+}
+
+func TestDownloadAndStoreMediaUnsupportedType(t *testing.T) {
+	withMediaStore(t, &fakeMediaStore{})
+
+	err := downloadAndStoreMedia(context.Background(), &fakeDownloader{}, &MessageStore{}, "chatJID", "msgID", "sticker", nil, nil, nil, "/v/direct_path")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "unsupported media type")
+}
+
+func TestDownloadAndStoreMediaNoMediaStoreConfigured(t *testing.T) {
+	withMediaStore(t, nil)
+
+	err := downloadAndStoreMedia(context.Background(), &fakeDownloader{}, &MessageStore{}, "chatJID", "msgID", "image", nil, nil, nil, "/v/direct_path")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "no media store configured")
+}
+
+// fakeS3Client implements manager.UploadAPIClient, recording the PutObject
+// call it receives instead of talking to S3. The multipart methods are never
+// exercised by the single-part uploads S3MediaStore performs in these tests,
+// but the interface requires them.
+type fakeS3Client struct {
+	putInput *s3.PutObjectInput
+}
+
+func (f *fakeS3Client) PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	f.putInput = params
+	return &s3.PutObjectOutput{}, nil
+}
+
+func (f *fakeS3Client) UploadPart(ctx context.Context, params *s3.UploadPartInput, optFns ...func(*s3.Options)) (*s3.UploadPartOutput, error) {
+	return &s3.UploadPartOutput{}, nil
+}
+
+func (f *fakeS3Client) CreateMultipartUpload(ctx context.Context, params *s3.CreateMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.CreateMultipartUploadOutput, error) {
+	return &s3.CreateMultipartUploadOutput{}, nil
+}
+
+func (f *fakeS3Client) CompleteMultipartUpload(ctx context.Context, params *s3.CompleteMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.CompleteMultipartUploadOutput, error) {
+	return &s3.CompleteMultipartUploadOutput{}, nil
+}
+
+func (f *fakeS3Client) AbortMultipartUpload(ctx context.Context, params *s3.AbortMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.AbortMultipartUploadOutput, error) {
+	return &s3.AbortMultipartUploadOutput{}, nil
+}
+
+func TestS3MediaStorePutReturnsPlainURIWhenUnsigned(t *testing.T) {
+	client := &fakeS3Client{}
+	store := &S3MediaStore{
+		client:   client,
+		uploader: manager.NewUploader(client),
+		bucket:   "my-bucket",
+	}
+
+	uri, err := store.Put(context.Background(), "chatJID", "msgID", "image", bytes.NewReader([]byte("data")))
+	require.NoError(t, err)
+	assert.Equal(t, "s3://my-bucket/chatJID/msgID.image", uri)
+	require.NotNil(t, client.putInput)
+	assert.Equal(t, "my-bucket", aws.ToString(client.putInput.Bucket))
+	assert.Equal(t, "chatJID/msgID.image", aws.ToString(client.putInput.Key))
+}
+
+func TestSetMessageLocalURI(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	store := &MessageStore{db: db}
+	mock.ExpectExec("UPDATE messages SET local_uri").
+		WithArgs("file:///tmp/x.jpg", "msgID", "chatJID").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	err = store.SetMessageLocalURI("msgID", "chatJID", "file:///tmp/x.jpg")
+	require.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// SearchOptions narrows a SearchMessages call. Zero values mean "no
+// restriction" for every field except Limit, which defaults to 50.
+type SearchOptions struct {
+	ChatJID    string
+	Since      time.Time
+	Until      time.Time
+	Sender     string
+	MediaTypes []string
+	Limit      int
+	Offset     int
+}
+
+// MessageHit is a single SearchMessages result.
+type MessageHit struct {
+	ChatJID   string
+	MessageID string
+	Sender    string
+	Content   string
+	Timestamp time.Time
+	MediaType string
+	// Snippet highlights the matched terms in context. Under FTS5 it comes
+	// from sqlite's snippet(); under the LIKE fallback it's just Content.
+	Snippet string
+}
+
+// ensureSearchIndex creates the messages_fts FTS5 virtual table and the
+// triggers that keep it in sync with the messages table. If the sqlite
+// driver was built without FTS5, CREATE VIRTUAL TABLE fails and
+// SearchMessages falls back to a LIKE-based query instead.
+//
+// messages_fts stores its own copy of content/sender/chat_name rather than
+// declaring messages as an external content table: messages' columns don't
+// line up positionally with the fts5 columns (id and chat_jid precede
+// content, and chat_name isn't a messages column at all - it's joined in
+// from chats), which is what external-content mode requires.
+//
+// StoreMessage re-stores a reprocessed message via INSERT OR REPLACE, which
+// deletes and re-inserts the row under a new rowid on a primary-key
+// conflict. That implicit delete only fires messages_fts_ad (and so keeps
+// the index in sync) when the connection has recursive_triggers enabled;
+// db must be opened with _recursive_triggers=1 in its DSN.
+//
+// The triggers remove a stale row with a plain DELETE rather than fts5's
+// special ('delete', rowid, ...) command: that command form only works
+// against external-content tables, which messages_fts (see above) isn't.
+func (store *MessageStore) ensureSearchIndex() {
+	_, err := store.db.Exec(`
+		CREATE VIRTUAL TABLE IF NOT EXISTS messages_fts USING fts5(content, sender, chat_name);
+
+		CREATE TRIGGER IF NOT EXISTS messages_fts_ai AFTER INSERT ON messages BEGIN
+			INSERT INTO messages_fts(rowid, content, sender, chat_name)
+			VALUES (new.rowid, new.content, new.sender, (SELECT name FROM chats WHERE jid = new.chat_jid));
+		END;
+
+		CREATE TRIGGER IF NOT EXISTS messages_fts_ad AFTER DELETE ON messages BEGIN
+			DELETE FROM messages_fts WHERE rowid = old.rowid;
+		END;
+
+		CREATE TRIGGER IF NOT EXISTS messages_fts_au AFTER UPDATE ON messages BEGIN
+			DELETE FROM messages_fts WHERE rowid = old.rowid;
+			INSERT INTO messages_fts(rowid, content, sender, chat_name)
+			VALUES (new.rowid, new.content, new.sender, (SELECT name FROM chats WHERE jid = new.chat_jid));
+		END;
+	`)
+	store.ftsAvailable = err == nil
+}
+
+// SearchMessages performs a full-text search over stored message content,
+// using SQLite FTS5 when available and falling back to a LIKE-based scan
+// otherwise.
+func (store *MessageStore) SearchMessages(query string, opts SearchOptions) ([]MessageHit, error) {
+	if opts.Limit <= 0 {
+		opts.Limit = 50
+	}
+
+	if store.ftsAvailable {
+		return store.searchMessagesFTS(query, opts)
+	}
+	return store.searchMessagesLike(query, opts)
+}
+
+func (store *MessageStore) searchMessagesFTS(query string, opts SearchOptions) ([]MessageHit, error) {
+	var b strings.Builder
+	b.WriteString(`
+		SELECT m.id, m.chat_jid, m.sender, m.content, m.timestamp, m.media_type,
+		       snippet(messages_fts, 0, '<b>', '</b>', '…', 10) AS snippet
+		FROM messages_fts
+		JOIN messages m ON m.rowid = messages_fts.rowid
+		WHERE messages_fts MATCH ?`)
+
+	args := []interface{}{ftsMatchPhrase(query)}
+	appendSearchFilters(&b, &args, opts)
+	b.WriteString(" ORDER BY m.timestamp DESC LIMIT ? OFFSET ?")
+	args = append(args, opts.Limit, opts.Offset)
+
+	return store.runSearchQuery(b.String(), args)
+}
+
+// ftsMatchPhrase wraps query as a quoted FTS5 string literal so it's matched
+// as literal phrase content rather than parsed as an FTS5 MATCH expression.
+// This is user search text, not a query language: any embedded quotes,
+// colons, parens, or bare AND/OR/NOT would otherwise be interpreted as FTS5
+// syntax and either error out or change what the query matches.
+func ftsMatchPhrase(query string) string {
+	return `"` + strings.ReplaceAll(query, `"`, `""`) + `"`
+}
+
+func (store *MessageStore) searchMessagesLike(query string, opts SearchOptions) ([]MessageHit, error) {
+	var b strings.Builder
+	b.WriteString(`
+		SELECT m.id, m.chat_jid, m.sender, m.content, m.timestamp, m.media_type, m.content AS snippet
+		FROM messages m
+		WHERE m.content LIKE ?`)
+
+	args := []interface{}{"%" + query + "%"}
+	appendSearchFilters(&b, &args, opts)
+	b.WriteString(" ORDER BY m.timestamp DESC LIMIT ? OFFSET ?")
+	args = append(args, opts.Limit, opts.Offset)
+
+	return store.runSearchQuery(b.String(), args)
+}
+
+// appendSearchFilters writes the shared ChatJID/Since/Until/Sender/MediaTypes
+// clauses onto b and appends their bind arguments to args.
+func appendSearchFilters(b *strings.Builder, args *[]interface{}, opts SearchOptions) {
+	if opts.ChatJID != "" {
+		b.WriteString(" AND m.chat_jid = ?")
+		*args = append(*args, opts.ChatJID)
+	}
+	if !opts.Since.IsZero() {
+		b.WriteString(" AND m.timestamp >= ?")
+		*args = append(*args, opts.Since)
+	}
+	if !opts.Until.IsZero() {
+		b.WriteString(" AND m.timestamp <= ?")
+		*args = append(*args, opts.Until)
+	}
+	if opts.Sender != "" {
+		b.WriteString(" AND m.sender = ?")
+		*args = append(*args, opts.Sender)
+	}
+	if len(opts.MediaTypes) > 0 {
+		placeholders := make([]string, len(opts.MediaTypes))
+		for i, mt := range opts.MediaTypes {
+			placeholders[i] = "?"
+			*args = append(*args, mt)
+		}
+		fmt.Fprintf(b, " AND m.media_type IN (%s)", strings.Join(placeholders, ", "))
+	}
+}
+
+func (store *MessageStore) runSearchQuery(query string, args []interface{}) ([]MessageHit, error) {
+	rows, err := store.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var hits []MessageHit
+	for rows.Next() {
+		var hit MessageHit
+		var mediaType sql.NullString
+		if err := rows.Scan(&hit.MessageID, &hit.ChatJID, &hit.Sender, &hit.Content, &hit.Timestamp, &mediaType, &hit.Snippet); err != nil {
+			return nil, err
+		}
+		hit.MediaType = mediaType.String
+		hits = append(hits, hit)
+	}
+	return hits, rows.Err()
+}
@@ -0,0 +1,66 @@
+//go:build fts5
+
+package main
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestSearchMessagesIntegration exercises SearchMessages against a real
+// mattn/go-sqlite3 database built with FTS5 support. Run with:
+//
+//	go test -tags fts5 ./...
+func TestSearchMessagesIntegration(t *testing.T) {
+	db, err := sql.Open("sqlite3", "file::memory:?_recursive_triggers=1")
+	require.NoError(t, err)
+	defer db.Close()
+
+	store, err := NewMessageStore(db)
+	require.NoError(t, err)
+	require.True(t, store.ftsAvailable, "sqlite3 driver must be built with the fts5 tag")
+
+	require.NoError(t, store.StoreChat("chatJID", "Friends", time.Now()))
+	require.NoError(t, store.StoreMessage("msg1", "chatJID", "alice", "let's grab lunch tomorrow", time.Now(), false, "", "", "", nil, nil, nil, 0, "", "", nil, ""))
+	require.NoError(t, store.StoreMessage("msg2", "chatJID", "bob", "sounds good, dinner too?", time.Now(), false, "", "", "", nil, nil, nil, 0, "", "", nil, ""))
+
+	hits, err := store.SearchMessages("lunch", SearchOptions{ChatJID: "chatJID"})
+	require.NoError(t, err)
+	require.Len(t, hits, 1)
+	assert.Equal(t, "msg1", hits[0].MessageID)
+	assert.Contains(t, hits[0].Snippet, "<b>lunch</b>")
+}
+
+// TestSearchMessagesIndexStaysInSyncOnReprocessedMessage guards against the
+// FTS5 sync triggers going stale when a message is re-stored under the same
+// (id, chat_jid) — e.g. a reconnect redelivery or a retried handler. Without
+// _recursive_triggers=1 on the connection, the implicit delete that INSERT
+// OR REPLACE performs on the primary-key conflict doesn't fire
+// messages_fts_ad, so the old index entry for the previous rowid is never
+// removed and stale content keeps matching.
+func TestSearchMessagesIndexStaysInSyncOnReprocessedMessage(t *testing.T) {
+	db, err := sql.Open("sqlite3", "file::memory:?_recursive_triggers=1")
+	require.NoError(t, err)
+	defer db.Close()
+
+	store, err := NewMessageStore(db)
+	require.NoError(t, err)
+	require.True(t, store.ftsAvailable, "sqlite3 driver must be built with the fts5 tag")
+
+	require.NoError(t, store.StoreChat("chatJID", "Friends", time.Now()))
+	require.NoError(t, store.StoreMessage("msg1", "chatJID", "alice", "hello world", time.Now(), false, "", "", "", nil, nil, nil, 0, "", "", nil, ""))
+	require.NoError(t, store.StoreMessage("msg1", "chatJID", "alice", "hello world updated", time.Now(), false, "", "", "", nil, nil, nil, 0, "", "", nil, ""))
+
+	var rowidCount int
+	require.NoError(t, db.QueryRow(`SELECT count(*) FROM messages_fts WHERE messages_fts MATCH 'hello'`).Scan(&rowidCount))
+	assert.Equal(t, 1, rowidCount, "re-storing a message under the same id must not leave a stale FTS entry behind")
+
+	hits, err := store.SearchMessages("hello", SearchOptions{ChatJID: "chatJID"})
+	require.NoError(t, err)
+	require.Len(t, hits, 1)
+	assert.Contains(t, hits[0].Content, "updated")
+}
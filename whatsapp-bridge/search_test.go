@@ -0,0 +1,98 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSearchMessagesFTS(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	store := &MessageStore{db: db, ftsAvailable: true}
+	rows := sqlmock.NewRows([]string{"id", "chat_jid", "sender", "content", "timestamp", "media_type", "snippet"}).
+		AddRow("msg1", "chatJID", "alice", "let's grab lunch", time.Now(), "", "let's grab <b>lunch</b>")
+
+	mock.ExpectQuery("SELECT m.id, m.chat_jid, m.sender, m.content, m.timestamp, m.media_type").
+		WithArgs(`"lunch"`, "chatJID", 50, 0).
+		WillReturnRows(rows)
+
+	hits, err := store.SearchMessages("lunch", SearchOptions{ChatJID: "chatJID"})
+	require.NoError(t, err)
+	require.Len(t, hits, 1)
+	assert.Equal(t, "let's grab <b>lunch</b>", hits[0].Snippet)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSearchMessagesFTSWithFilters(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	store := &MessageStore{db: db, ftsAvailable: true}
+	rows := sqlmock.NewRows([]string{"id", "chat_jid", "sender", "content", "timestamp", "media_type", "snippet"})
+
+	since := time.Now().Add(-24 * time.Hour)
+	mock.ExpectQuery("SELECT m.id, m.chat_jid, m.sender, m.content, m.timestamp, m.media_type").
+		WithArgs(`"report"`, "chatJID", since, "alice", "document", "image", 20, 5).
+		WillReturnRows(rows)
+
+	_, err = store.SearchMessages("report", SearchOptions{
+		ChatJID:    "chatJID",
+		Since:      since,
+		Sender:     "alice",
+		MediaTypes: []string{"document", "image"},
+		Limit:      20,
+		Offset:     5,
+	})
+	require.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSearchMessagesFTSEscapesSpecialCharacters(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	store := &MessageStore{db: db, ftsAvailable: true}
+	rows := sqlmock.NewRows([]string{"id", "chat_jid", "sender", "content", "timestamp", "media_type", "snippet"})
+
+	mock.ExpectQuery("SELECT m.id, m.chat_jid, m.sender, m.content, m.timestamp, m.media_type").
+		WithArgs(`"say ""hi"" OR bye"`, 50, 0).
+		WillReturnRows(rows)
+
+	_, err = store.SearchMessages(`say "hi" OR bye`, SearchOptions{})
+	require.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestFTSMatchPhrase(t *testing.T) {
+	assert.Equal(t, `"lunch"`, ftsMatchPhrase("lunch"))
+	assert.Equal(t, `"say ""hi"""`, ftsMatchPhrase(`say "hi"`))
+	assert.Equal(t, `"a AND b"`, ftsMatchPhrase("a AND b"))
+}
+
+func TestSearchMessagesLikeFallback(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	store := &MessageStore{db: db, ftsAvailable: false}
+	rows := sqlmock.NewRows([]string{"id", "chat_jid", "sender", "content", "timestamp", "media_type", "snippet"}).
+		AddRow("msg1", "chatJID", "alice", "let's grab lunch", time.Now(), "", "let's grab lunch")
+
+	mock.ExpectQuery("SELECT m.id, m.chat_jid, m.sender, m.content, m.timestamp, m.media_type").
+		WithArgs("%lunch%", 50, 0).
+		WillReturnRows(rows)
+
+	hits, err := store.SearchMessages("lunch", SearchOptions{})
+	require.NoError(t, err)
+	require.Len(t, hits, 1)
+	assert.Equal(t, "let's grab lunch", hits[0].Snippet)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// OverflowPolicy controls what MediaWorkerPool.Submit does when the job
+// queue is full.
+type OverflowPolicy int
+
+const (
+	// OverflowBlock makes Submit block until a queue slot frees up.
+	OverflowBlock OverflowPolicy = iota
+	// OverflowDrop makes Submit drop the job and return ErrQueueFull
+	// immediately instead of blocking.
+	OverflowDrop
+)
+
+// ErrQueueFull is returned by Submit when the queue is full and the pool's
+// OverflowPolicy is OverflowDrop.
+var ErrQueueFull = fmt.Errorf("media worker pool: queue is full")
+
+// ErrPoolClosed is returned by Submit once Shutdown has been called.
+var ErrPoolClosed = fmt.Errorf("media worker pool: pool is shut down")
+
+// WorkerPoolConfig configures a MediaWorkerPool.
+type WorkerPoolConfig struct {
+	// Workers is the number of goroutines processing jobs. Defaults to
+	// runtime.NumCPU() when zero.
+	Workers int
+	// QueueSize is the number of jobs that can be buffered before Submit
+	// applies Overflow. Defaults to 32 when zero.
+	QueueSize int
+	// Overflow controls Submit's behavior once the queue is full.
+	Overflow OverflowPolicy
+	// JobTimeout bounds how long a single job may run; zero means no
+	// per-job timeout.
+	JobTimeout time.Duration
+}
+
+// DefaultWorkerPoolConfig returns the pool configuration used by main: one
+// worker per CPU, a 32-job queue, and jobs blocking on overflow.
+func DefaultWorkerPoolConfig() WorkerPoolConfig {
+	return WorkerPoolConfig{
+		Workers:    runtime.NumCPU(),
+		QueueSize:  32,
+		Overflow:   OverflowBlock,
+		JobTimeout: 2 * time.Minute,
+	}
+}
+
+// Job is a unit of work submitted to a MediaWorkerPool.
+type Job func(ctx context.Context) error
+
+// MediaWorkerPool runs media downloads, thumbnail generation and hash
+// verification jobs across a bounded number of goroutines, so a burst of
+// incoming media never blocks the event loop or runs unbounded concurrent
+// downloads.
+type MediaWorkerPool struct {
+	cfg  WorkerPoolConfig
+	jobs chan Job
+	wg   sync.WaitGroup
+	once sync.Once
+	// shutdownMu guards against Submit sending on jobs after Shutdown has
+	// closed it: Submit holds a read lock while sending, Shutdown takes the
+	// write lock before closing so the two can never race.
+	shutdownMu sync.RWMutex
+	closed     bool
+}
+
+// NewMediaWorkerPool builds a MediaWorkerPool from cfg, filling in defaults
+// for zero-valued fields.
+func NewMediaWorkerPool(cfg WorkerPoolConfig) *MediaWorkerPool {
+	if cfg.Workers <= 0 {
+		cfg.Workers = runtime.NumCPU()
+	}
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = 32
+	}
+
+	return &MediaWorkerPool{
+		cfg:  cfg,
+		jobs: make(chan Job, cfg.QueueSize),
+	}
+}
+
+// NewTestWorkerPool returns a MediaWorkerPool whose Submit runs jobs
+// synchronously in the caller's goroutine, so tests get deterministic
+// ordering without spinning up real workers.
+func NewTestWorkerPool() *MediaWorkerPool {
+	pool := &MediaWorkerPool{cfg: WorkerPoolConfig{Workers: 0}}
+	return pool
+}
+
+// Run starts cfg.Workers goroutines pulling jobs off the queue until ctx is
+// canceled or Shutdown is called. Run is a no-op on a NewTestWorkerPool.
+func (p *MediaWorkerPool) Run(ctx context.Context) {
+	if p.jobs == nil {
+		return
+	}
+
+	for i := 0; i < p.cfg.Workers; i++ {
+		p.wg.Add(1)
+		go p.worker(ctx)
+	}
+}
+
+func (p *MediaWorkerPool) worker(ctx context.Context) {
+	defer p.wg.Done()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case job, ok := <-p.jobs:
+			if !ok {
+				return
+			}
+			p.runJob(ctx, job)
+		}
+	}
+}
+
+func (p *MediaWorkerPool) runJob(ctx context.Context, job Job) {
+	jobCtx := ctx
+	if p.cfg.JobTimeout > 0 {
+		var cancel context.CancelFunc
+		jobCtx, cancel = context.WithTimeout(ctx, p.cfg.JobTimeout)
+		defer cancel()
+	}
+	if err := job(jobCtx); err != nil {
+		log.Printf("media worker pool: job failed: %v", err)
+	}
+}
+
+// Submit enqueues job for processing. On a synchronous test pool (built via
+// NewTestWorkerPool) it runs the job inline and returns its error. Once
+// Shutdown has been called, Submit returns ErrPoolClosed instead of sending
+// on the (by then closed) jobs channel.
+func (p *MediaWorkerPool) Submit(job Job) error {
+	if p.jobs == nil {
+		return job(context.Background())
+	}
+
+	p.shutdownMu.RLock()
+	defer p.shutdownMu.RUnlock()
+	if p.closed {
+		return ErrPoolClosed
+	}
+
+	switch p.cfg.Overflow {
+	case OverflowDrop:
+		select {
+		case p.jobs <- job:
+			return nil
+		default:
+			return ErrQueueFull
+		}
+	default:
+		p.jobs <- job
+		return nil
+	}
+}
+
+// Shutdown stops accepting new jobs, waits for in-flight and already-queued
+// jobs to drain (or ctx to be canceled, whichever comes first), and returns
+// ctx.Err() if the drain didn't finish in time.
+func (p *MediaWorkerPool) Shutdown(ctx context.Context) error {
+	if p.jobs == nil {
+		return nil
+	}
+
+	var err error
+	p.once.Do(func() {
+		p.shutdownMu.Lock()
+		p.closed = true
+		close(p.jobs)
+		p.shutdownMu.Unlock()
+
+		done := make(chan struct{})
+		go func() {
+			p.wg.Wait()
+			close(done)
+		}()
+
+		select {
+		case <-done:
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+	})
+	return err
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMediaWorkerPoolRunsSubmittedJobs(t *testing.T) {
+	pool := NewMediaWorkerPool(WorkerPoolConfig{Workers: 2, QueueSize: 4})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	pool.Run(ctx)
+
+	var completed int32
+	for i := 0; i < 10; i++ {
+		require.NoError(t, pool.Submit(func(ctx context.Context) error {
+			atomic.AddInt32(&completed, 1)
+			return nil
+		}))
+	}
+
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), time.Second)
+	defer shutdownCancel()
+	require.NoError(t, pool.Shutdown(shutdownCtx))
+	assert.Equal(t, int32(10), atomic.LoadInt32(&completed))
+}
+
+func TestMediaWorkerPoolOverflowDrop(t *testing.T) {
+	pool := NewMediaWorkerPool(WorkerPoolConfig{Workers: 0, QueueSize: 1, Overflow: OverflowDrop})
+
+	require.NoError(t, pool.Submit(func(ctx context.Context) error { return nil }))
+	err := pool.Submit(func(ctx context.Context) error { return nil })
+	assert.ErrorIs(t, err, ErrQueueFull)
+}
+
+func TestMediaWorkerPoolSubmitAfterShutdownReturnsError(t *testing.T) {
+	pool := NewMediaWorkerPool(WorkerPoolConfig{Workers: 2, QueueSize: 4})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	pool.Run(ctx)
+
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), time.Second)
+	defer shutdownCancel()
+	require.NoError(t, pool.Shutdown(shutdownCtx))
+
+	err := pool.Submit(func(ctx context.Context) error { return nil })
+	assert.ErrorIs(t, err, ErrPoolClosed)
+}
+
+func TestMediaWorkerPoolConcurrentSubmitAndShutdownDoesNotPanic(t *testing.T) {
+	pool := NewMediaWorkerPool(WorkerPoolConfig{Workers: 2, QueueSize: 4})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	pool.Run(ctx)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_ = pool.Submit(func(ctx context.Context) error { return nil })
+		}()
+	}
+
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), time.Second)
+	defer shutdownCancel()
+	require.NoError(t, pool.Shutdown(shutdownCtx))
+	wg.Wait()
+}
+
+func TestNewTestWorkerPoolRunsSynchronously(t *testing.T) {
+	pool := NewTestWorkerPool()
+
+	var ran bool
+	err := pool.Submit(func(ctx context.Context) error {
+		ran = true
+		return fmt.Errorf("boom")
+	})
+
+	assert.True(t, ran)
+	assert.EqualError(t, err, "boom")
+}